@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/returntocorp/semgrep-network-broker/pkg"
+)
+
+func main() {
+	configPath := flag.String("config", "", "path to the broker's YAML config file")
+	flag.Parse()
+
+	if *configPath == "" {
+		log.Fatal("-config is required")
+	}
+
+	config, err := pkg.LoadConfig(*configPath)
+	if err != nil {
+		log.WithError(err).Fatal("broker.load_config")
+	}
+
+	if err := config.Start(); err != nil {
+		log.WithError(err).Fatal("broker.start")
+	}
+
+	select {}
+}
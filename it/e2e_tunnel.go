@@ -0,0 +1,110 @@
+package it
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/returntocorp/semgrep-network-broker/pkg"
+
+	"golang.org/x/net/proxy"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestWireguardInboundTunneling(t *testing.T) {
+	gatewayWireguardPort := mustGetFreePort()
+	gatewayWireguardAddress := mustGetRandomPrivateAddress()
+	gatewayPrivateKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		panic(err)
+	}
+	gatewayPublicKey := gatewayPrivateKey.PublicKey()
+
+	clientPrivateKey, _ := wgtypes.GeneratePrivateKey()
+	clientPublicKey := clientPrivateKey.PublicKey()
+	clientWireguardAddress := mustGetRandomPrivateAddress()
+
+	testWireguard := pkg.WireguardBase{
+		LocalAddress: gatewayWireguardAddress.String(),
+		PrivateKey:   hex.EncodeToString(gatewayPrivateKey[:]),
+		Peers: []pkg.WireguardPeer{
+			{
+				PublicKey:  hex.EncodeToString(clientPublicKey[:]),
+				AllowedIps: fmt.Sprintf("%v/128", clientWireguardAddress),
+			},
+		},
+		ListenPort: gatewayWireguardPort,
+	}
+	testDev, testNet, err := pkg.SetupWireguard(&testWireguard, false)
+	if err != nil {
+		t.Errorf("failed to setup wireguard: %v", err)
+	}
+
+	if err := testDev.Up(); err != nil {
+		t.Errorf("failed to bring up wireguard device: %v", err)
+	}
+	defer testDev.Down()
+
+	// set up an internal service reachable only through the WireGuard tunnel
+	internalListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer internalListener.Close()
+
+	go http.Serve(internalListener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Hello")
+	}))
+
+	internalAddr := internalListener.Addr().(*net.TCPAddr)
+	socks5Port := mustGetFreePort()
+
+	inboundConfig := &pkg.InboundProxyConfig{
+		Wireguard: pkg.WireguardBase{
+			LocalAddress: clientWireguardAddress.String(),
+			PrivateKey:   hex.EncodeToString(clientPrivateKey[:]),
+			Peers: []pkg.WireguardPeer{
+				{
+					PublicKey:                   hex.EncodeToString(gatewayPublicKey[:]),
+					AllowedIps:                  fmt.Sprintf("%v/128", gatewayWireguardAddress),
+					Endpoint:                    fmt.Sprintf("127.0.0.1:%v", gatewayWireguardPort),
+					PersistentKeepaliveInterval: 20,
+				},
+			},
+		},
+		Allowlist: []pkg.AllowlistItem{
+			{
+				Host:           "127.0.0.1",
+				Port:           fmt.Sprintf("%v", internalAddr.Port),
+				AllowedMethods: []string{"CONNECT"},
+			},
+		},
+		Socks5ListenPort: socks5Port,
+	}
+
+	inboundTeardown, err := inboundConfig.Start(false)
+	if err != nil {
+		t.Error(err)
+	}
+	defer inboundTeardown()
+
+	// it should tunnel a SOCKS5 CONNECT to an allowlisted host+port through the WireGuard device
+	dialer, err := proxy.SOCKS5("tcp", fmt.Sprintf("[%v]:%v", clientWireguardAddress, socks5Port), nil, testNet)
+	if err != nil {
+		t.Fatalf("failed to build socks5 dialer: %v", err)
+	}
+
+	conn, err := dialer.Dial("tcp", internalAddr.String())
+	if err != nil {
+		t.Errorf("socks5 tunnel to allowlisted destination failed: %v", err)
+	} else {
+		conn.Close()
+	}
+
+	// it should reject a SOCKS5 CONNECT to a destination that isn't allowlisted
+	if _, err := dialer.Dial("tcp", "127.0.0.1:1"); err == nil {
+		t.Error("socks5 tunnel to a non-allowlisted destination should have been rejected")
+	}
+}
@@ -0,0 +1,305 @@
+package pkg
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"gopkg.in/yaml.v3"
+)
+
+// AdminConfig configures the admin API: a bearer-token-protected HTTP server, bound
+// to its own loopback port, that lets operators reload the allowlist and manage
+// WireGuard peers at runtime instead of requiring a full restart (which would drop
+// every in-flight WireGuard session).
+type AdminConfig struct {
+	ListenAddr    string `yaml:"listenAddr" validate:"empty=false"`
+	BearerToken   string `yaml:"bearerToken" validate:"empty=false"`
+	AllowlistPath string `yaml:"allowlistPath"`
+}
+
+// peerHandshakeStats summarizes one peer's line in the device's UAPI config dump.
+type peerHandshakeStats struct {
+	PublicKey         string `json:"publicKey"`
+	LastHandshakeSecs int64  `json:"lastHandshakeSecs"`
+	ReceiveBytes      int64  `json:"receiveBytes"`
+	TransmitBytes     int64  `json:"transmitBytes"`
+}
+
+// SetDevice attaches the live WireGuard device to the config so the admin API can
+// add/remove peers and read handshake stats. It must be called before Start if
+// config.Admin is set.
+func (config *InboundProxyConfig) SetDevice(d *device.Device) {
+	config.device = d
+}
+
+// startAdminAPI starts the admin server described by config.Admin. allowlist is the
+// atomic pointer the proxy handler reads on every request, so a reload takes effect
+// for the very next request with no restart and no dropped WireGuard sessions.
+func startAdminAPI(config *InboundProxyConfig, allowlist *atomic.Pointer[Allowlist]) error {
+	admin := config.Admin
+
+	host, _, err := net.SplitHostPort(admin.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("invalid admin.listenAddr: %v", err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("admin.listenAddr must be a loopback address, got %q", admin.ListenAddr)
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.Use(gin.Recovery(), bearerAuth(admin.BearerToken))
+
+	reload := func() error {
+		if admin.AllowlistPath == "" {
+			return fmt.Errorf("admin.allowlistPath is not configured")
+		}
+
+		data, err := os.ReadFile(admin.AllowlistPath)
+		if err != nil {
+			return fmt.Errorf("failed to read allowlist: %v", err)
+		}
+
+		var reloaded Allowlist
+		if err := yaml.Unmarshal(data, &reloaded); err != nil {
+			return fmt.Errorf("failed to parse allowlist: %v", err)
+		}
+
+		allowlist.Store(&reloaded)
+		log.WithField("path", admin.AllowlistPath).Info("admin.allowlist_reloaded")
+		return nil
+	}
+
+	r.POST("/reload", func(c *gin.Context) {
+		if err := reload(); err != nil {
+			log.WithError(err).Error("admin.reload")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "reloaded"})
+	})
+
+	r.POST("/peers", func(c *gin.Context) {
+		var peer WireguardPeer
+		if err := c.BindJSON(&peer); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := config.upsertPeer(peer); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	r.DELETE("/peers/:publicKey", func(c *gin.Context) {
+		if err := config.removePeer(c.Param("publicKey")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	r.GET("/config", func(c *gin.Context) {
+		stats, err := config.peerHandshakeStats()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"allowlist": redactAllowlistForAdmin(allowlist.Load()),
+			"peers":     stats,
+		})
+	})
+
+	// a SIGHUP is the conventional "reload your config" signal on top of the POST
+	// /reload endpoint, for operators who'd rather send a signal than make a request
+	if admin.AllowlistPath != "" {
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+		go func() {
+			for range sighup {
+				if err := reload(); err != nil {
+					log.WithError(err).Error("admin.sighup_reload")
+				}
+			}
+		}()
+	}
+
+	go func() {
+		if err := r.Run(admin.ListenAddr); err != nil {
+			log.Panic(fmt.Errorf("failed to start admin server: %v", err))
+		}
+	}()
+
+	log.WithField("path", admin.ListenAddr).Info("admin.configured")
+	return nil
+}
+
+// sensitiveHeaderNames are header names whose configured value GET /config must
+// never echo back verbatim, since anyone holding the admin bearer token would
+// otherwise be handed every allowlisted destination's credentials in one response.
+var sensitiveHeaderNames = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"set-cookie":          true,
+	"x-api-key":           true,
+}
+
+// redactAllowlistForAdmin returns a copy of list suitable for returning from
+// GET /config: each item's SetRequestHeaders has its credential-bearing values
+// blanked out. UpstreamProxyConfig's own MarshalJSON takes care of its secrets.
+func redactAllowlistForAdmin(list *Allowlist) Allowlist {
+	if list == nil {
+		return nil
+	}
+
+	redacted := make(Allowlist, len(*list))
+	for i, item := range *list {
+		item.SetRequestHeaders = redactHeaderValues(item.SetRequestHeaders)
+		redacted[i] = item
+	}
+	return redacted
+}
+
+func redactHeaderValues(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+
+	redacted := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if sensitiveHeaderNames[strings.ToLower(name)] {
+			redacted[name] = "[REDACTED]"
+		} else {
+			redacted[name] = value
+		}
+	}
+	return redacted
+}
+
+func bearerAuth(token string) gin.HandlerFunc {
+	expected := []byte("Bearer " + token)
+	return func(c *gin.Context) {
+		provided := []byte(c.GetHeader("Authorization"))
+		if subtle.ConstantTimeCompare(provided, expected) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// UAPIConfig renders the peer as a WireGuard UAPI config-protocol fragment, suitable
+// for passing to device.IpcSet to add or update it at runtime.
+// See https://www.wireguard.com/xplatform/#configuration-protocol for the wire format.
+func (peer *WireguardPeer) UAPIConfig() (string, error) {
+	publicKey, err := wgtypes.ParseKey(peer.PublicKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %v", err)
+	}
+
+	var config strings.Builder
+	fmt.Fprintf(&config, "public_key=%s\n", hex.EncodeToString(publicKey[:]))
+	fmt.Fprintf(&config, "replace_allowed_ips=true\n")
+
+	for _, allowedIp := range strings.Split(peer.AllowedIps, ",") {
+		fmt.Fprintf(&config, "allowed_ip=%s\n", strings.TrimSpace(allowedIp))
+	}
+
+	if peer.Endpoint != "" {
+		addr, err := net.ResolveUDPAddr("udp", peer.Endpoint)
+		if err != nil {
+			return "", fmt.Errorf("invalid endpoint: %v", err)
+		}
+		fmt.Fprintf(&config, "endpoint=%s\n", addr.String())
+	}
+
+	if peer.PersistentKeepaliveInterval != 0 {
+		fmt.Fprintf(&config, "persistent_keepalive_interval=%d\n", peer.PersistentKeepaliveInterval)
+	}
+
+	return config.String(), nil
+}
+
+func (config *InboundProxyConfig) upsertPeer(peer WireguardPeer) error {
+	if config.device == nil {
+		return fmt.Errorf("admin api: no wireguard device attached")
+	}
+
+	uapiConfig, err := peer.UAPIConfig()
+	if err != nil {
+		return fmt.Errorf("invalid peer: %v", err)
+	}
+
+	return config.device.IpcSet(uapiConfig)
+}
+
+func (config *InboundProxyConfig) removePeer(publicKey string) error {
+	if config.device == nil {
+		return fmt.Errorf("admin api: no wireguard device attached")
+	}
+
+	uapiConfig := fmt.Sprintf("public_key=%s\nremove=true\n", publicKey)
+	return config.device.IpcSet(uapiConfig)
+}
+
+// peerHandshakeStats parses the device's UAPI config dump into a per-peer summary.
+// See https://www.wireguard.com/xplatform/#configuration-protocol for the wire format.
+func (config *InboundProxyConfig) peerHandshakeStats() ([]peerHandshakeStats, error) {
+	if config.device == nil {
+		return nil, fmt.Errorf("admin api: no wireguard device attached")
+	}
+
+	dump, err := config.device.IpcGet()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device config: %v", err)
+	}
+
+	var stats []peerHandshakeStats
+	var current *peerHandshakeStats
+	for _, line := range strings.Split(dump, "\n") {
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "public_key":
+			if current != nil {
+				stats = append(stats, *current)
+			}
+			current = &peerHandshakeStats{PublicKey: value}
+		case "last_handshake_time_sec":
+			if current != nil {
+				current.LastHandshakeSecs, _ = strconv.ParseInt(value, 10, 64)
+			}
+		case "rx_bytes":
+			if current != nil {
+				current.ReceiveBytes, _ = strconv.ParseInt(value, 10, 64)
+			}
+		case "tx_bytes":
+			if current != nil {
+				current.TransmitBytes, _ = strconv.ParseInt(value, 10, 64)
+			}
+		}
+	}
+	if current != nil {
+		stats = append(stats, *current)
+	}
+
+	return stats, nil
+}
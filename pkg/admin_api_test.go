@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBearerAuthRejectsWrongOrMissingToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler := bearerAuth("s3cr3t")
+	called := false
+	next := func(c *gin.Context) { called = true }
+
+	cases := []struct {
+		name    string
+		header  string
+		allowed bool
+	}{
+		{"correct token", "Bearer s3cr3t", true},
+		{"wrong token", "Bearer nope", false},
+		{"missing header", "", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			called = false
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/config", nil)
+			if tc.header != "" {
+				c.Request.Header.Set("Authorization", tc.header)
+			}
+			c.Handlers = gin.HandlersChain{handler, next}
+			c.Next()
+
+			if called != tc.allowed {
+				t.Fatalf("expected handler called=%v, got %v", tc.allowed, called)
+			}
+			if !tc.allowed && w.Code != http.StatusUnauthorized {
+				t.Fatalf("expected HTTP 401, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestRedactHeaderValues(t *testing.T) {
+	redacted := redactHeaderValues(map[string]string{
+		"Authorization": "Bearer top-secret",
+		"X-Request-Id":  "abc123",
+	})
+
+	if redacted["Authorization"] != "[REDACTED]" {
+		t.Fatalf("expected Authorization to be redacted, got %q", redacted["Authorization"])
+	}
+	if redacted["X-Request-Id"] != "abc123" {
+		t.Fatalf("expected a non-sensitive header to pass through unchanged, got %q", redacted["X-Request-Id"])
+	}
+}
+
+func TestRedactAllowlistForAdminRedactsSetRequestHeaders(t *testing.T) {
+	list := Allowlist{
+		{
+			URL: "https://example.com",
+			SetRequestHeaders: map[string]string{
+				"Authorization": "Bearer top-secret",
+			},
+		},
+	}
+
+	redacted := redactAllowlistForAdmin(&list)
+
+	if redacted[0].SetRequestHeaders["Authorization"] != "[REDACTED]" {
+		t.Fatalf("expected SetRequestHeaders to be redacted, got %q", redacted[0].SetRequestHeaders["Authorization"])
+	}
+	if list[0].SetRequestHeaders["Authorization"] != "Bearer top-secret" {
+		t.Fatal("redactAllowlistForAdmin must not mutate the live allowlist it was given")
+	}
+}
@@ -0,0 +1,248 @@
+package pkg
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// RateLimitConfig bounds how often a single AllowlistItem's destination can be
+// proxied to, independent of how many distinct Semgrep clients are sending requests.
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `yaml:"requestsPerSecond" validate:"empty=false"`
+	Burst             int     `yaml:"burst" validate:"empty=false"`
+}
+
+// CircuitBreakerConfig trips a destination's circuit open after a run of consecutive
+// proxy failures, so a misbehaving or unreachable dependency doesn't keep eating
+// requests and timeouts, then lets a handful of probe requests through to check if
+// it has recovered before fully closing again.
+type CircuitBreakerConfig struct {
+	FailureThreshold int           `yaml:"failureThreshold" validate:"empty=false"`
+	OpenDuration     time.Duration `yaml:"openDuration" validate:"empty=false"`
+	HalfOpenProbes   int           `yaml:"halfOpenProbes" validate:"empty=false"`
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+var (
+	destinationStateGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "semgrep_network_broker_destination_state",
+		Help: "Circuit breaker state per destination (0=closed, 1=open, 2=half_open)",
+	}, []string{"destination"})
+	destinationFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "semgrep_network_broker_destination_failures_total",
+		Help: "Total proxy failures per destination",
+	}, []string{"destination"})
+	destinationRateLimitedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "semgrep_network_broker_destination_rate_limited_total",
+		Help: "Total requests rejected by the per-destination rate limiter",
+	}, []string{"destination"})
+)
+
+func init() {
+	prometheus.MustRegister(destinationStateGauge, destinationFailuresTotal, destinationRateLimitedTotal)
+}
+
+// destinationHealth tracks rate limiting and circuit-breaker state for a single
+// AllowlistItem's destination.
+type destinationHealth struct {
+	name      string
+	breaker   CircuitBreakerConfig
+	rateLimit RateLimitConfig
+	limiter   *rate.Limiter
+
+	mu                  sync.Mutex
+	state               circuitState
+	openUntil           time.Time
+	consecutiveFailures int
+	halfOpenProbesLeft  int
+}
+
+func newDestinationHealth(name string, rateLimit *RateLimitConfig, breaker *CircuitBreakerConfig) *destinationHealth {
+	d := &destinationHealth{name: name}
+	d.applyConfig(rateLimit, breaker)
+	destinationStateGauge.WithLabelValues(name).Set(float64(circuitClosed))
+	return d
+}
+
+// applyConfig updates the rate limiter and circuit breaker settings this health
+// tracks to match a (possibly new) AllowlistItem config. The limiter is only
+// recreated when its settings actually changed, so a reload that leaves a
+// destination's rate limit untouched doesn't reset its token bucket.
+func (d *destinationHealth) applyConfig(rateLimit *RateLimitConfig, breaker *CircuitBreakerConfig) {
+	newRateLimit := RateLimitConfig{}
+	if rateLimit != nil {
+		newRateLimit = *rateLimit
+	}
+	if rateLimit == nil {
+		d.limiter = nil
+	} else if d.limiter == nil || d.rateLimit != newRateLimit {
+		d.limiter = rate.NewLimiter(rate.Limit(rateLimit.RequestsPerSecond), rateLimit.Burst)
+	}
+	d.rateLimit = newRateLimit
+
+	newBreaker := CircuitBreakerConfig{}
+	if breaker != nil {
+		newBreaker = *breaker
+	}
+	if newBreaker != d.breaker {
+		// a changed circuit breaker config invalidates whatever open/half-open
+		// state accumulated under the old one; most importantly, disabling the
+		// breaker entirely (newBreaker.HalfOpenProbes == 0) would otherwise leave
+		// an already-open circuit stuck rejecting every request forever, since
+		// half-open would always have zero probes left to spend
+		d.state = circuitClosed
+		d.consecutiveFailures = 0
+		d.openUntil = time.Time{}
+		d.halfOpenProbesLeft = 0
+		destinationStateGauge.WithLabelValues(d.name).Set(float64(circuitClosed))
+	}
+	d.breaker = newBreaker
+}
+
+// AllowRequest reports whether a request to this destination should proceed, taking
+// the rate limiter and circuit breaker into account.
+func (d *destinationHealth) AllowRequest() (bool, string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.state == circuitOpen {
+		if time.Now().Before(d.openUntil) {
+			return false, "circuit_open"
+		}
+		d.state = circuitHalfOpen
+		d.halfOpenProbesLeft = d.breaker.HalfOpenProbes
+		destinationStateGauge.WithLabelValues(d.name).Set(float64(circuitHalfOpen))
+	}
+
+	if d.state == circuitHalfOpen && d.halfOpenProbesLeft <= 0 {
+		return false, "circuit_open"
+	}
+
+	if d.limiter != nil && !d.limiter.Allow() {
+		destinationRateLimitedTotal.WithLabelValues(d.name).Inc()
+		return false, "rate_limited"
+	}
+
+	if d.state == circuitHalfOpen {
+		d.halfOpenProbesLeft--
+	}
+
+	return true, ""
+}
+
+// RecordSuccess resets the consecutive-failure count and closes the circuit again if
+// it was open or half-open.
+func (d *destinationHealth) RecordSuccess() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.consecutiveFailures = 0
+	if d.state != circuitClosed {
+		d.state = circuitClosed
+		destinationStateGauge.WithLabelValues(d.name).Set(float64(circuitClosed))
+	}
+}
+
+// RecordFailure trips the breaker open once consecutive failures reach the
+// configured threshold, and reopens it immediately if a half-open probe fails.
+func (d *destinationHealth) RecordFailure() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	destinationFailuresTotal.WithLabelValues(d.name).Inc()
+
+	if d.breaker.FailureThreshold <= 0 {
+		return
+	}
+
+	if d.state == circuitHalfOpen {
+		d.open()
+		return
+	}
+
+	d.consecutiveFailures++
+	if d.consecutiveFailures >= d.breaker.FailureThreshold {
+		d.open()
+	}
+}
+
+func (d *destinationHealth) open() {
+	d.state = circuitOpen
+	d.openUntil = time.Now().Add(d.breaker.OpenDuration)
+	destinationStateGauge.WithLabelValues(d.name).Set(float64(circuitOpen))
+}
+
+func (d *destinationHealth) Degraded() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state != circuitClosed
+}
+
+// destinationHealthRegistry lazily creates and caches a destinationHealth per
+// allowlisted destination name.
+type destinationHealthRegistry struct {
+	mu    sync.Mutex
+	byKey map[string]*destinationHealth
+}
+
+func newDestinationHealthRegistry() *destinationHealthRegistry {
+	return &destinationHealthRegistry{byKey: map[string]*destinationHealth{}}
+}
+
+// Get returns the cached destinationHealth for item's destination, creating one on
+// first use. A destination's rate limit/circuit breaker settings are re-applied on
+// every call (cheap compared to the rate-limiter check it guards), so an admin API
+// reload that swaps in a new Allowlist takes effect for existing destinations too,
+// instead of only ones seen for the first time after the reload.
+func (r *destinationHealthRegistry) Get(item *AllowlistItem) *destinationHealth {
+	r.mu.Lock()
+	health, exists := r.byKey[item.URL]
+	if !exists {
+		health = newDestinationHealth(item.URL, item.RateLimit, item.CircuitBreaker)
+		r.byKey[item.URL] = health
+	}
+	r.mu.Unlock()
+
+	if exists {
+		health.mu.Lock()
+		health.applyConfig(item.RateLimit, item.CircuitBreaker)
+		health.mu.Unlock()
+	}
+	return health
+}
+
+// Degraded returns the names of every destination whose circuit is currently open
+// or half-open, for reporting on the healthcheck endpoint.
+func (r *destinationHealthRegistry) Degraded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	degraded := []string{}
+	for name, health := range r.byKey {
+		if health.Degraded() {
+			degraded = append(degraded, name)
+		}
+	}
+	return degraded
+}
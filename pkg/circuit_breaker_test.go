@@ -0,0 +1,103 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDestinationHealthCircuitTransitions(t *testing.T) {
+	d := newDestinationHealth("dest", nil, &CircuitBreakerConfig{
+		FailureThreshold: 2,
+		OpenDuration:     10 * time.Millisecond,
+		HalfOpenProbes:   1,
+	})
+
+	if allow, _ := d.AllowRequest(); !allow {
+		t.Fatal("expected request to be allowed while circuit is closed")
+	}
+
+	d.RecordFailure()
+	if d.Degraded() {
+		t.Fatal("circuit should still be closed after one failure below the threshold")
+	}
+
+	d.RecordFailure()
+	if !d.Degraded() {
+		t.Fatal("circuit should be open after reaching the failure threshold")
+	}
+	if allow, reason := d.AllowRequest(); allow || reason != "circuit_open" {
+		t.Fatalf("expected circuit_open rejection, got allow=%v reason=%q", allow, reason)
+	}
+
+	time.Sleep(15 * time.Millisecond)
+
+	if allow, _ := d.AllowRequest(); !allow {
+		t.Fatal("expected a half-open probe to be allowed once openDuration has elapsed")
+	}
+	if allow, _ := d.AllowRequest(); allow {
+		t.Fatal("expected only halfOpenProbes requests to be allowed while half-open")
+	}
+
+	d.RecordSuccess()
+	if d.Degraded() {
+		t.Fatal("circuit should close again after a successful half-open probe")
+	}
+}
+
+func TestDestinationHealthRegistryGetAppliesUpdatedConfig(t *testing.T) {
+	registry := newDestinationHealthRegistry()
+
+	item := &AllowlistItem{
+		URL:            "https://example.com",
+		CircuitBreaker: &CircuitBreakerConfig{FailureThreshold: 1, OpenDuration: time.Hour, HalfOpenProbes: 1},
+	}
+
+	health := registry.Get(item)
+	health.RecordFailure()
+	if !health.Degraded() {
+		t.Fatal("expected circuit to open with a failureThreshold of 1")
+	}
+
+	// a reload raises the threshold for the same destination: the cached
+	// destinationHealth should pick up the new config, not keep serving the stale one
+	reloaded := &AllowlistItem{
+		URL:            "https://example.com",
+		CircuitBreaker: &CircuitBreakerConfig{FailureThreshold: 5, OpenDuration: time.Hour, HalfOpenProbes: 1},
+	}
+
+	same := registry.Get(reloaded)
+	if same != health {
+		t.Fatal("expected the same cached destinationHealth to be returned for the same URL")
+	}
+	if same.breaker.FailureThreshold != 5 {
+		t.Fatalf("expected failureThreshold to be refreshed to 5, got %d", same.breaker.FailureThreshold)
+	}
+}
+
+func TestDestinationHealthRegistryGetRecoversFromDisabledBreakerReload(t *testing.T) {
+	registry := newDestinationHealthRegistry()
+
+	item := &AllowlistItem{
+		URL:            "https://example.com",
+		CircuitBreaker: &CircuitBreakerConfig{FailureThreshold: 1, OpenDuration: time.Hour, HalfOpenProbes: 1},
+	}
+
+	health := registry.Get(item)
+	health.RecordFailure()
+	if !health.Degraded() {
+		t.Fatal("expected circuit to open with a failureThreshold of 1")
+	}
+
+	// an admin reload removes the circuit breaker for this destination entirely;
+	// the already-open circuit must not be left permanently stuck rejecting every
+	// request (it would be, if halfOpenProbesLeft stayed pinned at 0 forever)
+	disabled := &AllowlistItem{URL: "https://example.com"}
+	same := registry.Get(disabled)
+
+	if same.Degraded() {
+		t.Fatal("expected the circuit to be reset to closed once its breaker was disabled")
+	}
+	if allow, reason := same.AllowRequest(); !allow {
+		t.Fatalf("expected requests to be allowed once the breaker was disabled, got reason %q", reason)
+	}
+}
@@ -0,0 +1,66 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the top-level broker configuration. A single binary can run the inbound
+// proxy (accepting traffic from remote WireGuard peers and forwarding it out to the
+// public internet), the outbound proxy (accepting local traffic and forwarding it
+// through its own WireGuard tunnel to a remote inbound peer), or both side by side,
+// depending on which of Inbound/Outbound are set.
+type Config struct {
+	Inbound  *InboundProxyConfig  `yaml:"inbound"`
+	Outbound *OutboundProxyConfig `yaml:"outbound"`
+}
+
+// LoadConfig reads and parses the broker config from a YAML file at path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	if config.Inbound == nil && config.Outbound == nil {
+		return nil, fmt.Errorf("config must set at least one of inbound or outbound")
+	}
+
+	return &config, nil
+}
+
+// Start brings up whichever of Inbound/Outbound are configured, each behind its own
+// WireGuard netstack, so a single binary can serve either direction or both at once.
+func (config *Config) Start() error {
+	if config.Inbound != nil {
+		dev, tnet, err := SetupWireguard(&config.Inbound.Wireguard, false)
+		if err != nil {
+			return fmt.Errorf("failed to set up inbound wireguard: %v", err)
+		}
+		config.Inbound.SetDevice(dev)
+
+		if err := config.Inbound.Start(tnet); err != nil {
+			return fmt.Errorf("failed to start inbound proxy: %v", err)
+		}
+	}
+
+	if config.Outbound != nil {
+		_, tnet, err := SetupWireguard(&config.Outbound.Wireguard, false)
+		if err != nil {
+			return fmt.Errorf("failed to set up outbound wireguard: %v", err)
+		}
+
+		if err := config.Outbound.Start(tnet); err != nil {
+			return fmt.Errorf("failed to start outbound proxy: %v", err)
+		}
+	}
+
+	return nil
+}
@@ -2,10 +2,13 @@ package pkg
 
 import (
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strings"
+	"sync/atomic"
 
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
@@ -30,14 +33,33 @@ func (config *InboundProxyConfig) Start(tnet *netstack.Net) error {
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
 
+	destinationHealths := newDestinationHealthRegistry()
+	upstreamTransports := newUpstreamTransportRegistry()
+
+	// the allowlist is held behind an atomic pointer rather than read straight off
+	// config so the admin API can swap it out for a freshly-reloaded one without a
+	// restart, and without racing in-flight requests
+	allowlist := &atomic.Pointer[Allowlist]{}
+	allowlist.Store(&config.Allowlist)
+
 	// we want this proxy to be transparent, so don't un-escape characters in the URL
 	r.UseRawPath = true
 	r.UnescapePathValues = false
 
 	r.Use(LoggerWithConfig(log.StandardLogger(), config.Logging.SkipPaths), gin.Recovery())
 
+	// handle HTTP CONNECT tunneling (e.g. git+ssh, databases, container registries)
+	// before gin's normal routing, since CONNECT requests carry no usable path
+	r.Use(connectHandler(allowlist, tnet))
+
 	// setup healthcheck
-	r.GET(healthcheckPath, func(c *gin.Context) { c.JSON(http.StatusOK, "OK") })
+	r.GET(healthcheckPath, func(c *gin.Context) {
+		if degraded := destinationHealths.Degraded(); len(degraded) > 0 {
+			c.JSON(http.StatusOK, gin.H{"status": "degraded", "degradedDestinations": degraded})
+			return
+		}
+		c.JSON(http.StatusOK, "OK")
+	})
 	log.WithField("path", healthcheckPath).Info("healthcheck.configured")
 
 	// setup metrics
@@ -58,7 +80,7 @@ func (config *InboundProxyConfig) Start(tnet *netstack.Net) error {
 			return
 		}
 
-		allowlistMatch, exists := config.Allowlist.FindMatch(c.Request.Method, destinationUrl)
+		allowlistMatch, exists := allowlist.Load().FindMatch(c.Request.Method, destinationUrl)
 		if !exists {
 			logger.Warn("allowlist.reject")
 			c.Header(errorResponseHeader, "1")
@@ -68,7 +90,24 @@ func (config *InboundProxyConfig) Start(tnet *netstack.Net) error {
 
 		logger.WithField("allowlist_match", allowlistMatch.URL).Info("proxy.request")
 
+		health := destinationHealths.Get(&allowlistMatch)
+		if allow, reason := health.AllowRequest(); !allow {
+			logger.WithField("reason", reason).Warn("proxy.destination_unavailable")
+			c.Header(errorResponseHeader, reason)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": reason})
+			return
+		}
+
+		transport, err := upstreamTransports.Get(&allowlistMatch)
+		if err != nil {
+			logger.WithError(err).Error("proxy.upstream_transport")
+			c.Header(errorResponseHeader, "1")
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+
 		proxy := httputil.ReverseProxy{
+			Transport: transport,
 			Director: func(req *http.Request) {
 				req.URL = destinationUrl
 				req.Host = destinationUrl.Host
@@ -81,8 +120,31 @@ func (config *InboundProxyConfig) Start(tnet *netstack.Net) error {
 				for _, headerToRemove := range allowlistMatch.RemoveResponseHeaders {
 					resp.Header.Del(headerToRemove)
 				}
+				if allowlistMatch.ResponsePolicy != nil {
+					if err := allowlistMatch.ResponsePolicy.Apply(resp); err != nil {
+						logger.WithError(err).Warn("proxy.response_policy_reject")
+						resp.Body.Close()
+						resp.StatusCode = http.StatusBadGateway
+						resp.Header.Set(errorResponseHeader, "1")
+						resp.Body = io.NopCloser(strings.NewReader(err.Error()))
+						resp.ContentLength = int64(len(err.Error()))
+						resp.Header.Set("Content-Length", fmt.Sprintf("%d", resp.ContentLength))
+						return nil
+					}
+				}
+				if resp.StatusCode >= http.StatusInternalServerError {
+					health.RecordFailure()
+				} else {
+					health.RecordSuccess()
+				}
 				return nil
 			},
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				health.RecordFailure()
+				logger.WithError(err).Warn("proxy.upstream_error")
+				w.Header().Set(errorResponseHeader, "1")
+				w.WriteHeader(http.StatusBadGateway)
+			},
 		}
 		proxy.ServeHTTP(c.Writer, c.Request)
 	})
@@ -100,6 +162,22 @@ func (config *InboundProxyConfig) Start(tnet *netstack.Net) error {
 		}
 	}()
 
+	// optionally run a SOCKS5 CONNECT-only listener alongside the HTTP proxy, for
+	// clients that can't speak HTTP/HTTP CONNECT themselves
+	if config.Socks5ListenPort != 0 {
+		if err := StartSocks5Listener(allowlist, tnet, config.Socks5ListenPort); err != nil {
+			return err
+		}
+	}
+
+	// optionally run the admin API (reload/peer management/config dump) on its own
+	// loopback listener
+	if config.Admin != nil {
+		if err := startAdminAPI(config, allowlist); err != nil {
+			return err
+		}
+	}
+
 	log.Info("broker.start")
 
 	return nil
@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	ginprometheus "github.com/zsais/go-gin-prometheus"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+	"gopkg.in/dealancer/validate.v2"
+)
+
+// OutboundProxyConfig runs the broker on the client side: it accepts plain HTTP
+// requests from a local process on loopback, checks the destination against an
+// allowlist, and forwards matching requests out through its own WireGuard netstack to
+// a remote peer that terminates them on the public internet. It shares the
+// allowlist/header-rewrite machinery with InboundProxyConfig so the two can run side
+// by side in a single binary, selected via Config's Inbound/Outbound fields.
+type OutboundProxyConfig struct {
+	Wireguard         WireguardBase `yaml:"wireguard"`
+	ListenAddr        string        `yaml:"listenAddr" validate:"empty=false"`
+	MetricsListenAddr string        `yaml:"metricsListenAddr" validate:"empty=false"`
+	Allowlist         Allowlist     `yaml:"allowlist"`
+	Logging           LoggingConfig `yaml:"logging"`
+}
+
+func (config *OutboundProxyConfig) Start(tnet *netstack.Net) error {
+	// ensure config is valid
+	if err := validate.Validate(config); err != nil {
+		return fmt.Errorf("invalid outbound config: %v", err)
+	}
+
+	// setup metrics + healthcheck on their own loopback listener, separate from the
+	// proxy listener that accepts requests from the local semgrep process
+	gin.SetMode(gin.ReleaseMode)
+	metricsRouter := gin.New()
+	metricsRouter.Use(gin.Recovery())
+	metricsRouter.GET(healthcheckPath, func(c *gin.Context) { c.JSON(http.StatusOK, "OK") })
+	log.WithField("path", healthcheckPath).Info("outbound.healthcheck.configured")
+
+	// a distinct subsystem from InboundProxyConfig's "gin" so the two can register
+	// their gin-request collectors on the shared default Prometheus registry without
+	// colliding when both run in the same process
+	p := ginprometheus.NewPrometheus("gin_outbound")
+	p.Use(metricsRouter)
+	log.WithField("path", p.MetricsPath).Info("outbound.metrics.configured")
+
+	go func() {
+		if err := metricsRouter.Run(config.MetricsListenAddr); err != nil {
+			log.Panic(fmt.Errorf("failed to start outbound metrics server: %v", err))
+		}
+	}()
+
+	// setup http proxy
+	r := gin.New()
+
+	// we want this proxy to be transparent, so don't un-escape characters in the URL
+	r.UseRawPath = true
+	r.UnescapePathValues = false
+
+	r.Use(LoggerWithConfig(log.StandardLogger(), config.Logging.SkipPaths), gin.Recovery())
+
+	r.Any(proxyPath, func(c *gin.Context) {
+		logger := log.WithFields(GetRequestFields(c))
+		destinationUrl, err := url.Parse(c.Param(destinationUrlParam)[1:])
+		logger = logger.WithField("destinationUrl", destinationUrl)
+
+		if err != nil {
+			logger.WithError(err).Warn("outbound_proxy.destination_url_parse")
+			c.Header(errorResponseHeader, "1")
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		allowlistMatch, exists := config.Allowlist.FindMatch(c.Request.Method, destinationUrl)
+		if !exists {
+			logger.Warn("allowlist.reject")
+			c.Header(errorResponseHeader, "1")
+			c.JSON(http.StatusForbidden, gin.H{"error": "url is not in allowlist"})
+			return
+		}
+
+		logger.WithField("allowlist_match", allowlistMatch.URL).Info("outbound_proxy.request")
+
+		proxy := httputil.ReverseProxy{
+			// dial the destination through the WireGuard tunnel, so the remote peer
+			// is the one that actually resolves/terminates the connection
+			Transport: &http.Transport{
+				DialContext: tnet.DialContext,
+			},
+			Director: func(req *http.Request) {
+				req.URL = destinationUrl
+				req.Host = destinationUrl.Host
+				for headerName, headerValue := range allowlistMatch.SetRequestHeaders {
+					req.Header.Set(headerName, headerValue)
+				}
+			},
+			ModifyResponse: func(resp *http.Response) error {
+				resp.Header.Set(proxyResponseHeader, "1")
+				for _, headerToRemove := range allowlistMatch.RemoveResponseHeaders {
+					resp.Header.Del(headerToRemove)
+				}
+				return nil
+			},
+		}
+		proxy.ServeHTTP(c.Writer, c.Request)
+	})
+
+	go func() {
+		if err := r.Run(config.ListenAddr); err != nil {
+			log.Panic(fmt.Errorf("failed to start outbound http server: %v", err))
+		}
+	}()
+
+	log.Info("outbound_broker.start")
+
+	return nil
+}
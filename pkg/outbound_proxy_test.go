@@ -0,0 +1,13 @@
+package pkg
+
+import "testing"
+
+func TestOutboundProxyConfigStartRejectsInvalidConfig(t *testing.T) {
+	// validation happens before the WireGuard netstack is touched, so this is
+	// exercisable without standing up a real tunnel
+	config := &OutboundProxyConfig{}
+
+	if err := config.Start(nil); err == nil {
+		t.Fatal("expected Start to reject a config missing listenAddr/metricsListenAddr")
+	}
+}
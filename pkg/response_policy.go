@@ -0,0 +1,187 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+)
+
+// ResponsePolicy bounds what an AllowlistItem's response is allowed to look like
+// before it's streamed back across the tunnel: how large it can be, what content
+// types are acceptable, and what patterns (tokens, PII, ...) must be redacted from
+// the body before it crosses the boundary.
+//
+// MaxResponseBytes can only be enforced as a clean rejection (a 502 with no partial
+// body reaching the client) when the upstream advertises Content-Length up front, in
+// which case Apply rejects before httputil.ReverseProxy writes any response headers.
+// If Content-Length is absent or understates the body (chunked encoding, a lying or
+// buggy upstream), the 200 has already been flushed by the time policedBody notices
+// the overage mid-stream, so the connection is instead cut short rather than turned
+// into a 502 — see policedBody.Read.
+type ResponsePolicy struct {
+	MaxResponseBytes    int64    `yaml:"maxResponseBytes"`
+	AllowedContentTypes []string `yaml:"allowedContentTypes"`
+	RedactPatterns      []string `yaml:"redactPatterns"`
+
+	compiledRedactPatterns []*regexp.Regexp
+}
+
+// Apply rejects the response outright if its Content-Type isn't allowlisted or its
+// advertised Content-Length already exceeds MaxResponseBytes, and otherwise wraps
+// resp.Body so that, as it streams through, it enforces MaxResponseBytes and redacts
+// RedactPatterns without buffering the whole body. Apply runs inside
+// httputil.ReverseProxy.ModifyResponse, before any response headers are written to
+// the client, so an error returned here is the only way to turn an overage into a
+// clean 502 instead of a truncated 200 — see the MaxResponseBytes doc comment.
+func (policy *ResponsePolicy) Apply(resp *http.Response) error {
+	if !policy.contentTypeAllowed(resp.Header.Get("Content-Type")) {
+		return fmt.Errorf("response content-type %q is not allowed", resp.Header.Get("Content-Type"))
+	}
+
+	if policy.MaxResponseBytes > 0 && resp.ContentLength >= 0 && resp.ContentLength > policy.MaxResponseBytes {
+		return fmt.Errorf("response content-length %d exceeds maxResponseBytes %d", resp.ContentLength, policy.MaxResponseBytes)
+	}
+
+	patterns, err := policy.compile()
+	if err != nil {
+		return err
+	}
+
+	if policy.MaxResponseBytes <= 0 && len(patterns) == 0 {
+		return nil
+	}
+
+	overlap := 0
+	if len(patterns) > 0 {
+		overlap = redactOverlapBytes
+	}
+	resp.Body = &policedBody{ReadCloser: resp.Body, maxBytes: policy.MaxResponseBytes, patterns: patterns, overlap: overlap}
+	return nil
+}
+
+func (policy *ResponsePolicy) contentTypeAllowed(contentType string) bool {
+	if len(policy.AllowedContentTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+	for _, allowed := range policy.AllowedContentTypes {
+		if allowed == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+func (policy *ResponsePolicy) compile() ([]*regexp.Regexp, error) {
+	if policy.compiledRedactPatterns != nil || len(policy.RedactPatterns) == 0 {
+		return policy.compiledRedactPatterns, nil
+	}
+
+	compiled := make([]*regexp.Regexp, 0, len(policy.RedactPatterns))
+	for _, pattern := range policy.RedactPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redactPattern %q: %v", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+
+	policy.compiledRedactPatterns = compiled
+	return compiled, nil
+}
+
+// redactOverlapBytes bounds how many trailing bytes of unredacted data a policedBody
+// holds back from each underlying Read instead of scanning and emitting it
+// immediately. Without this, a secret whose bytes straddle two separate upstream
+// reads (entirely possible for a TLS/TCP-fragmented response) would never appear
+// whole in either chunk's scan and would stream to the client unredacted. Patterns
+// in RedactPatterns are assumed not to match anything longer than this.
+const redactOverlapBytes = 256
+
+// policedBody streams an http.Response body through the size cap and redaction
+// patterns a chunk at a time, so large or slow responses aren't buffered in memory
+// and the response keeps flushing to the client as it arrives. To catch secrets
+// that straddle two underlying reads, it only redacts-and-emits up to
+// len(raw)-overlap bytes of what it's read so far, carrying the rest forward to be
+// rescanned together with the next chunk.
+//
+// By the time Read notices a MaxResponseBytes overage here, httputil.ReverseProxy
+// has already written the response status line and headers to the client (Apply only
+// catches overages this late when the upstream didn't advertise an honest
+// Content-Length up front). So hitting maxBytes mid-stream does not and cannot turn
+// into a 502: it surfaces as io.ErrUnexpectedEOF, which ends the response body short,
+// leaving the client with a truncated 200 rather than a clean error.
+type policedBody struct {
+	io.ReadCloser
+	maxBytes int64
+	patterns []*regexp.Regexp
+	overlap  int
+
+	read       int64
+	raw        []byte
+	buf        []byte
+	pendingErr error
+}
+
+func (b *policedBody) Read(p []byte) (int, error) {
+	if len(b.buf) == 0 && b.pendingErr == nil {
+		chunk := make([]byte, len(p))
+		n, err := b.ReadCloser.Read(chunk)
+		if n > 0 {
+			b.read += int64(n)
+			b.raw = append(b.raw, chunk[:n]...)
+		}
+		if err != nil {
+			b.pendingErr = err
+		}
+		if b.maxBytes > 0 && b.read > b.maxBytes {
+			b.pendingErr = io.ErrUnexpectedEOF
+			// drop whatever this read brought in past the cap, so no more than
+			// maxBytes of the upstream body is ever emitted
+			b.raw = b.raw[:len(b.raw)-int(b.read-b.maxBytes)]
+		}
+
+		// once there's no more data coming (pendingErr set), flush everything we're
+		// still holding; otherwise keep the trailing `overlap` bytes unscanned in
+		// case they're the prefix of a pattern match that continues in the next read
+		emitUpTo := len(b.raw)
+		if b.pendingErr == nil {
+			if emitUpTo > b.overlap {
+				emitUpTo -= b.overlap
+			} else {
+				emitUpTo = 0
+			}
+			// don't cut through a match that straddles the emit boundary: hold the
+			// whole match back so it's rescanned intact together with the next chunk
+			for _, pattern := range b.patterns {
+				for _, loc := range pattern.FindAllIndex(b.raw, -1) {
+					if loc[0] < emitUpTo && loc[1] > emitUpTo {
+						emitUpTo = loc[0]
+					}
+				}
+			}
+		}
+
+		if emitUpTo > 0 {
+			data := append([]byte(nil), b.raw[:emitUpTo]...)
+			for _, pattern := range b.patterns {
+				data = pattern.ReplaceAll(data, []byte("[REDACTED]"))
+			}
+			b.buf = append(b.buf, data...)
+			b.raw = append([]byte(nil), b.raw[emitUpTo:]...)
+		}
+	}
+
+	if len(b.buf) > 0 {
+		n := copy(p, b.buf)
+		b.buf = b.buf[n:]
+		return n, nil
+	}
+
+	return 0, b.pendingErr
+}
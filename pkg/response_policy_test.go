@@ -0,0 +1,194 @@
+package pkg
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fixedChunkReadCloser hands back at most chunkSize bytes per Read, regardless of
+// how large the caller's buffer is, to simulate a TLS/TCP-fragmented upstream
+// response whose chunk boundaries don't line up with anything meaningful.
+type fixedChunkReadCloser struct {
+	data      []byte
+	chunkSize int
+}
+
+func (r *fixedChunkReadCloser) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := r.chunkSize
+	if n > len(r.data) {
+		n = len(r.data)
+	}
+	if n > len(p) {
+		n = len(p)
+	}
+	copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func (r *fixedChunkReadCloser) Close() error { return nil }
+
+func TestResponsePolicyRedactsSecretSplitAcrossReads(t *testing.T) {
+	body := []byte("prefix sk-ABCDEFGHIJKLMNOP suffix")
+	policy := &ResponsePolicy{RedactPatterns: []string{`sk-[A-Za-z0-9]+`}}
+
+	resp := &http.Response{
+		Header: http.Header{"Content-Type": []string{"text/plain"}},
+		Body:   &fixedChunkReadCloser{data: body, chunkSize: 4},
+	}
+
+	if err := policy.Apply(resp); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read policed body: %v", err)
+	}
+
+	want := "prefix [REDACTED] suffix"
+	if string(got) != want {
+		t.Fatalf("got %q, want %q (secret split across 4-byte reads should still be redacted)", got, want)
+	}
+}
+
+func TestResponsePolicyEnforcesMaxResponseBytes(t *testing.T) {
+	policy := &ResponsePolicy{MaxResponseBytes: 8}
+
+	resp := &http.Response{
+		Header:        http.Header{"Content-Type": []string{"text/plain"}},
+		ContentLength: -1, // unknown, as with a chunked upstream response
+		Body:          &fixedChunkReadCloser{data: []byte("this response is too long"), chunkSize: 4},
+	}
+
+	if err := policy.Apply(resp); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	_, err := io.ReadAll(resp.Body)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF once maxResponseBytes was exceeded, got %v", err)
+	}
+}
+
+func TestResponsePolicyRejectsEagerlyOnKnownContentLengthOverage(t *testing.T) {
+	policy := &ResponsePolicy{MaxResponseBytes: 8}
+
+	resp := &http.Response{
+		Header:        http.Header{"Content-Type": []string{"text/plain"}},
+		ContentLength: 25,
+		Body:          &fixedChunkReadCloser{data: []byte("this response is too long"), chunkSize: 4},
+	}
+
+	if err := policy.Apply(resp); err == nil {
+		t.Fatal("expected Apply to reject eagerly when Content-Length already exceeds maxResponseBytes")
+	}
+}
+
+// newPolicedReverseProxy wires a ResponsePolicy into an httputil.ReverseProxy the same
+// way InboundProxyConfig.Start does, so these tests exercise the actual interaction
+// between ResponsePolicy.Apply and ReverseProxy.ServeHTTP (which writes response
+// headers before it reads the body) rather than just policedBody in isolation.
+func newPolicedReverseProxy(upstream *httptest.Server, policy *ResponsePolicy) *httputil.ReverseProxy {
+	upstreamURL, _ := url.Parse(upstream.URL)
+	return &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL = upstreamURL
+			req.Host = upstreamURL.Host
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			if err := policy.Apply(resp); err != nil {
+				resp.Body.Close()
+				resp.StatusCode = http.StatusBadGateway
+				resp.Body = io.NopCloser(strings.NewReader(err.Error()))
+				resp.ContentLength = -1
+				resp.Header.Del("Content-Length")
+				return nil
+			}
+			return nil
+		},
+	}
+}
+
+func TestResponsePolicyReverseProxyReturnsCleanBadGatewayOnKnownOverage(t *testing.T) {
+	body := "this response is way too long for the policy"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(body))
+	}))
+	defer upstream.Close()
+
+	policy := &ResponsePolicy{MaxResponseBytes: 8}
+	proxy := newPolicedReverseProxy(upstream, policy)
+	frontend := httptest.NewServer(proxy)
+	defer frontend.Close()
+
+	resp, err := http.Get(frontend.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// the upstream advertised Content-Length up front, so Apply could reject before
+	// ReverseProxy wrote any headers: the client gets a clean 502, not a truncated 200
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected a clean 502 when the overage was knowable from Content-Length, got %d", resp.StatusCode)
+	}
+}
+
+func TestResponsePolicyReverseProxyTruncatesUnknownLengthOverageInstead(t *testing.T) {
+	body := "this response is way too long for the policy"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.(http.Flusher).Flush() // force chunked transfer-encoding: Content-Length is unknown
+		w.Write([]byte(body))
+	}))
+	defer upstream.Close()
+
+	policy := &ResponsePolicy{MaxResponseBytes: 8}
+	proxy := newPolicedReverseProxy(upstream, policy)
+	frontend := httptest.NewServer(proxy)
+	defer frontend.Close()
+
+	resp, err := http.Get(frontend.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Content-Length was unknown, so Apply couldn't reject before ReverseProxy already
+	// wrote the upstream's 200 to the client: this documents that the overage surfaces
+	// as a truncated body on an already-committed 200, not as a 502
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the already-flushed upstream status 200 to survive, got %d", resp.StatusCode)
+	}
+	got, readErr := io.ReadAll(resp.Body)
+	if len(got) >= len(body) {
+		t.Fatalf("expected the body to be cut short by the size cap, got the full %d bytes with err %v", len(got), readErr)
+	}
+}
+
+func TestResponsePolicyRejectsDisallowedContentType(t *testing.T) {
+	policy := &ResponsePolicy{AllowedContentTypes: []string{"application/json"}}
+
+	resp := &http.Response{
+		Header: http.Header{"Content-Type": []string{"text/html"}},
+		Body:   &fixedChunkReadCloser{data: []byte("<html></html>")},
+	}
+
+	if err := policy.Apply(resp); err == nil {
+		t.Fatal("expected Apply to reject a content-type that isn't allowlisted")
+	}
+}
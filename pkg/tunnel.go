@@ -0,0 +1,231 @@
+package pkg
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+const socks5Version = 0x05
+const socks5CmdConnect = 0x01
+const socks5AtypIPv4 = 0x01
+const socks5AtypDomain = 0x03
+const socks5AtypIPv6 = 0x04
+const socks5ReplySuccess = 0x00
+const socks5ReplyNotAllowed = 0x02
+const socks5ReplyHostUnreachable = 0x04
+
+// connectHandler intercepts HTTP CONNECT requests on the proxy listener before gin's
+// routing gets a chance to see them (a CONNECT request carries no usable path), matches
+// the requested host+port against the allowlist, and tunnels the raw bytes through the
+// WireGuard netstack for protocols that don't speak HTTP (git+ssh, databases, registries).
+func connectHandler(allowlist *atomic.Pointer[Allowlist], tnet *netstack.Net) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodConnect {
+			return
+		}
+
+		logger := log.WithFields(GetRequestFields(c)).WithField("destination", c.Request.Host)
+
+		host, port, err := net.SplitHostPort(c.Request.Host)
+		if err != nil {
+			logger.WithError(err).Warn("tunnel.destination_parse")
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		if _, exists := allowlist.Load().FindHostMatch(host, port); !exists {
+			logger.Warn("allowlist.reject")
+			c.Header(errorResponseHeader, "1")
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		tunnelConnect(logger, c.Writer, tnet, net.JoinHostPort(host, port))
+		c.Abort()
+	}
+}
+
+// tunnelConnect hijacks the client connection, dials the destination through the
+// WireGuard netstack, and splices the two connections together until either side
+// closes.
+func tunnelConnect(logger *log.Entry, w http.ResponseWriter, tnet *netstack.Net, destination string) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		logger.Error("tunnel.hijack_unsupported")
+		http.Error(w, "connect tunneling unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	clientConn, _, err := hijacker.Hijack()
+	if err != nil {
+		logger.WithError(err).Error("tunnel.hijack")
+		return
+	}
+	defer clientConn.Close()
+
+	destConn, err := tnet.Dial("tcp", destination)
+	if err != nil {
+		logger.WithError(err).Warn("tunnel.dial")
+		fmt.Fprintf(clientConn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	defer destConn.Close()
+
+	fmt.Fprintf(clientConn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+
+	logger.Info("tunnel.connect")
+	pipeConns(clientConn, destConn)
+}
+
+func pipeConns(a net.Conn, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// StartSocks5Listener runs a no-auth SOCKS5 CONNECT-only proxy on the WireGuard
+// netstack listener, matching each requested destination against the allowlist before
+// tunneling it out through tnet, the same way connectHandler does for HTTP CONNECT.
+func StartSocks5Listener(allowlist *atomic.Pointer[Allowlist], tnet *netstack.Net, listenPort int) error {
+	listener, err := tnet.ListenTCP(&net.TCPAddr{Port: listenPort})
+	if err != nil {
+		return fmt.Errorf("failed to start SOCKS5 listener: %v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				log.WithError(err).Error("socks5.accept")
+				return
+			}
+			go handleSocks5Conn(allowlist, tnet, conn)
+		}
+	}()
+
+	log.WithField("port", listenPort).Info("socks5.configured")
+	return nil
+}
+
+func handleSocks5Conn(allowlist *atomic.Pointer[Allowlist], tnet *netstack.Net, conn net.Conn) {
+	defer conn.Close()
+	logger := log.WithField("remote", conn.RemoteAddr())
+
+	r := bufio.NewReader(conn)
+
+	// greeting: version, nmethods, methods...
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		logger.WithError(err).Warn("socks5.greeting")
+		return
+	}
+	if header[0] != socks5Version {
+		logger.Warn("socks5.unsupported_version")
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		logger.WithError(err).Warn("socks5.methods")
+		return
+	}
+	// no-auth only
+	if _, err := conn.Write([]byte{socks5Version, 0x00}); err != nil {
+		return
+	}
+
+	// request: version, cmd, rsv, atyp, dst.addr, dst.port
+	reqHeader := make([]byte, 4)
+	if _, err := io.ReadFull(r, reqHeader); err != nil {
+		logger.WithError(err).Warn("socks5.request")
+		return
+	}
+	if reqHeader[1] != socks5CmdConnect {
+		writeSocks5Reply(conn, socks5ReplyNotAllowed)
+		return
+	}
+
+	host, err := readSocks5Addr(r, reqHeader[3])
+	if err != nil {
+		logger.WithError(err).Warn("socks5.address")
+		writeSocks5Reply(conn, socks5ReplyHostUnreachable)
+		return
+	}
+
+	portBytes := make([]byte, 2)
+	if _, err := io.ReadFull(r, portBytes); err != nil {
+		logger.WithError(err).Warn("socks5.port")
+		return
+	}
+	port := fmt.Sprintf("%d", binary.BigEndian.Uint16(portBytes))
+
+	logger = logger.WithField("destination", net.JoinHostPort(host, port))
+
+	if _, exists := allowlist.Load().FindHostMatch(host, port); !exists {
+		logger.Warn("allowlist.reject")
+		writeSocks5Reply(conn, socks5ReplyNotAllowed)
+		return
+	}
+
+	destConn, err := tnet.Dial("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		logger.WithError(err).Warn("socks5.dial")
+		writeSocks5Reply(conn, socks5ReplyHostUnreachable)
+		return
+	}
+	defer destConn.Close()
+
+	writeSocks5Reply(conn, socks5ReplySuccess)
+
+	logger.Info("socks5.connect")
+	pipeConns(conn, destConn)
+}
+
+func readSocks5Addr(r *bufio.Reader, atyp byte) (string, error) {
+	switch atyp {
+	case socks5AtypIPv4:
+		addr := make([]byte, 4)
+		if _, err := io.ReadFull(r, addr); err != nil {
+			return "", err
+		}
+		return net.IP(addr).String(), nil
+	case socks5AtypIPv6:
+		addr := make([]byte, 16)
+		if _, err := io.ReadFull(r, addr); err != nil {
+			return "", err
+		}
+		return net.IP(addr).String(), nil
+	case socks5AtypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", err
+		}
+		return string(domain), nil
+	default:
+		return "", fmt.Errorf("unsupported address type: %v", atyp)
+	}
+}
+
+func writeSocks5Reply(conn net.Conn, reply byte) {
+	// bind address/port are unused for CONNECT-only support, so always report 0.0.0.0:0
+	conn.Write([]byte{socks5Version, reply, 0x00, socks5AtypIPv4, 0, 0, 0, 0, 0, 0})
+}
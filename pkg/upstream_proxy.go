@@ -0,0 +1,141 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// UpstreamProxyConfig routes an allowlisted destination through a corporate forward
+// proxy instead of dialing it directly. This lets the inbound broker be deployed in
+// environments where outbound traffic is only permitted through a mandatory proxy.
+type UpstreamProxyConfig struct {
+	URL         string `yaml:"url" validate:"empty=false"`
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	BearerToken string `yaml:"bearerToken"`
+}
+
+// MarshalJSON reports only whether each credential is configured, not its value, so
+// that UpstreamProxyConfig can be safely round-tripped through the admin API's
+// GET /config without handing out the upstream proxy's credentials to anyone holding
+// the admin bearer token.
+func (upstream UpstreamProxyConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		URL            string `json:"url"`
+		HasUsername    bool   `json:"hasUsername,omitempty"`
+		HasPassword    bool   `json:"hasPassword,omitempty"`
+		HasBearerToken bool   `json:"hasBearerToken,omitempty"`
+	}{
+		URL:            upstream.URL,
+		HasUsername:    upstream.Username != "",
+		HasPassword:    upstream.Password != "",
+		HasBearerToken: upstream.BearerToken != "",
+	})
+}
+
+// Transport builds an http.RoundTripper that dials through the configured upstream
+// proxy, authenticating both the CONNECT handshake (for https destinations) and
+// plain-old forward-proxied http requests (which net/http never attaches
+// ProxyConnectHeader to).
+func (upstream *UpstreamProxyConfig) Transport() (http.RoundTripper, error) {
+	proxyUrl, err := url.Parse(upstream.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream proxy url: %v", err)
+	}
+
+	if upstream.Username != "" || upstream.Password != "" {
+		// net/http sends Proxy-Authorization derived from the proxy URL's userinfo
+		// for both CONNECT and plain-proxy requests, unlike ProxyConnectHeader which
+		// only ever applies to CONNECT
+		proxyUrl.User = url.UserPassword(upstream.Username, upstream.Password)
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyURL(proxyUrl),
+	}
+
+	if upstream.BearerToken == "" {
+		return transport, nil
+	}
+
+	// a bearer token has nowhere to go in the proxy URL's userinfo, so cover the
+	// CONNECT case via ProxyConnectHeader and wrap the transport to tag every
+	// plain-proxy request too
+	bearerHeader := "Bearer " + upstream.BearerToken
+	transport.ProxyConnectHeader = http.Header{"Proxy-Authorization": []string{bearerHeader}}
+	return &bearerProxyTransport{transport: transport, authorizationHeader: bearerHeader}, nil
+}
+
+// bearerProxyTransport tags plain (non-CONNECT) outgoing requests with a
+// Proxy-Authorization header before handing them to the wrapped transport, since
+// net/http only ever sets that header itself from the proxy URL's userinfo (Basic
+// auth) or, for CONNECT requests only, from ProxyConnectHeader.
+type bearerProxyTransport struct {
+	transport           http.RoundTripper
+	authorizationHeader string
+}
+
+func (rt *bearerProxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// an https destination is reached by CONNECT-tunneling to it (already
+	// authenticated via ProxyConnectHeader) and then sending this request's headers
+	// straight through to the destination server over the resulting TLS connection,
+	// so tagging it here would hand the upstream proxy's bearer token to the
+	// destination itself rather than the proxy
+	if req.URL.Scheme != "http" {
+		return rt.transport.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Proxy-Authorization", rt.authorizationHeader)
+	return rt.transport.RoundTrip(req)
+}
+
+// cachedUpstreamTransport pairs a built http.RoundTripper with the UpstreamProxyConfig
+// it was built from, so upstreamTransportRegistry can tell whether a reload actually
+// changed anything before paying to rebuild it.
+type cachedUpstreamTransport struct {
+	config    UpstreamProxyConfig
+	transport http.RoundTripper
+}
+
+// upstreamTransportRegistry lazily builds and caches one http.RoundTripper per
+// allowlisted destination's upstream proxy config, keyed by AllowlistItem.URL.
+// Building a fresh *http.Transport (and its own connection pool) on every proxied
+// request would leak a socket per request under sustained traffic, since nothing
+// would ever reuse or close the idle connections it opens.
+type upstreamTransportRegistry struct {
+	mu    sync.Mutex
+	byKey map[string]*cachedUpstreamTransport
+}
+
+func newUpstreamTransportRegistry() *upstreamTransportRegistry {
+	return &upstreamTransportRegistry{byKey: map[string]*cachedUpstreamTransport{}}
+}
+
+// Get returns the cached http.RoundTripper for item's upstream proxy config,
+// building (or, if a reload changed the config since it was last built, rebuilding)
+// one on demand. It returns a nil http.RoundTripper, nil error if item has no
+// upstream proxy configured, so the destination is dialed directly.
+func (r *upstreamTransportRegistry) Get(item *AllowlistItem) (http.RoundTripper, error) {
+	if item.Upstream == nil {
+		return nil, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cached, exists := r.byKey[item.URL]; exists && cached.config == *item.Upstream {
+		return cached.transport, nil
+	}
+
+	transport, err := item.Upstream.Transport()
+	if err != nil {
+		return nil, err
+	}
+
+	r.byKey[item.URL] = &cachedUpstreamTransport{config: *item.Upstream, transport: transport}
+	return transport, nil
+}
@@ -0,0 +1,137 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUpstreamProxyConfigTransportSendsBasicAuthOnPlainRequest(t *testing.T) {
+	var gotAuth string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Proxy-Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	upstream := &UpstreamProxyConfig{URL: proxy.URL, Username: "alice", Password: "hunter2"}
+	transport, err := upstream.Transport()
+	if err != nil {
+		t.Fatalf("Transport failed: %v", err)
+	}
+
+	// a plain (non-CONNECT) request through the upstream proxy: net/http never
+	// attaches ProxyConnectHeader here, so credentials must come from elsewhere
+	req, _ := http.NewRequest(http.MethodGet, "http://destination.example/path", nil)
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if gotAuth == "" {
+		t.Fatal("expected Proxy-Authorization to be sent on a plain-http proxied request")
+	}
+}
+
+func TestUpstreamProxyConfigTransportSendsBearerAuthOnPlainRequest(t *testing.T) {
+	var gotAuth string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Proxy-Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	upstream := &UpstreamProxyConfig{URL: proxy.URL, BearerToken: "tok-123"}
+	transport, err := upstream.Transport()
+	if err != nil {
+		t.Fatalf("Transport failed: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://destination.example/path", nil)
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if gotAuth != "Bearer tok-123" {
+		t.Fatalf("expected Proxy-Authorization %q, got %q", "Bearer tok-123", gotAuth)
+	}
+}
+
+type recordingRoundTripper struct {
+	gotHeader http.Header
+}
+
+func (rt *recordingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.gotHeader = req.Header.Clone()
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func TestBearerProxyTransportDoesNotLeakTokenToHttpsDestination(t *testing.T) {
+	inner := &recordingRoundTripper{}
+	rt := &bearerProxyTransport{transport: inner, authorizationHeader: "Bearer tok-123"}
+
+	// the CONNECT handshake to an https destination is already authenticated via
+	// ProxyConnectHeader; this request object's headers go straight to the
+	// destination server over the resulting tunnel, so it must not carry the
+	// upstream proxy's bearer token
+	req, _ := http.NewRequest(http.MethodGet, "https://destination.example/path", nil)
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip failed: %v", err)
+	}
+
+	if got := inner.gotHeader.Get("Proxy-Authorization"); got != "" {
+		t.Fatalf("expected no Proxy-Authorization header on an https-destination request, got %q", got)
+	}
+}
+
+func TestUpstreamTransportRegistryReusesTransportAcrossRequests(t *testing.T) {
+	registry := newUpstreamTransportRegistry()
+	item := &AllowlistItem{
+		URL:      "https://example.com",
+		Upstream: &UpstreamProxyConfig{URL: "http://proxy.example:3128"},
+	}
+
+	first, err := registry.Get(item)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	second, err := registry.Get(item)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	if first != second {
+		t.Fatal("expected the same cached http.RoundTripper to be returned across requests, not a fresh one built each time")
+	}
+}
+
+func TestUpstreamTransportRegistryRebuildsOnConfigChange(t *testing.T) {
+	registry := newUpstreamTransportRegistry()
+	item := &AllowlistItem{
+		URL:      "https://example.com",
+		Upstream: &UpstreamProxyConfig{URL: "http://proxy.example:3128"},
+	}
+
+	first, err := registry.Get(item)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	// an admin reload changes this destination's upstream proxy config
+	reloaded := &AllowlistItem{
+		URL:      "https://example.com",
+		Upstream: &UpstreamProxyConfig{URL: "http://proxy.example:3128", Username: "alice", Password: "hunter2"},
+	}
+
+	second, err := registry.Get(reloaded)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	if first == second {
+		t.Fatal("expected a changed upstream proxy config to produce a rebuilt transport")
+	}
+}